@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	credentials "cloud.google.com/go/iam/credentials/apiv1"
+	"cloud.google.com/go/iam/credentials/apiv1/credentialspb"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// accessMode controls how finalizeObjectAccess makes an uploaded object
+// reachable. Uniform Bucket-Level Access (GCS's modern default) rejects
+// per-object ACL mutation, so public-acl only works on legacy fine-grained
+// buckets.
+type accessMode string
+
+const (
+	// accessModePublicACL sets a per-object AllUsers:READER ACL, same as the
+	// server has always done. On Uniform Bucket-Level Access the ACL call
+	// fails; the failure is only logged and the upload still succeeds.
+	accessModePublicACL accessMode = "public-acl"
+	// accessModeUniformSigned never touches ACLs and instead hands back a V4
+	// signed GET URL, suitable for a uniform bucket that stays private.
+	accessModeUniformSigned accessMode = "uniform-signed"
+	// accessModeUniformIAM assumes the bucket already grants
+	// allUsers:objectViewer at the IAM level and just returns the canonical
+	// object URL.
+	accessModeUniformIAM accessMode = "uniform-iam"
+)
+
+// configuredAccessMode reads ACCESS_MODE, defaulting to the historical
+// public-acl behavior.
+func configuredAccessMode() accessMode {
+	switch accessMode(os.Getenv("ACCESS_MODE")) {
+	case accessModeUniformSigned:
+		return accessModeUniformSigned
+	case accessModeUniformIAM:
+		return accessModeUniformIAM
+	default:
+		return accessModePublicACL
+	}
+}
+
+// privateObjectMetadataKey/Value mark an uploaded object's custom metadata so
+// read endpoints (imageHandler, listHandler) can tell it was never meant to
+// be served directly: either the caller asked for ?public=0, or the
+// configured access mode relies on signed URLs rather than public grants.
+// Without this, those endpoints would proxy bytes straight off the bucket
+// for any guessed object name, making the "keep it private" promise of
+// uniform-signed mode cosmetic.
+const (
+	privateObjectMetadataKey   = "picture-uploader-access"
+	privateObjectMetadataValue = "private"
+)
+
+// objectIsPrivate reports whether an object uploaded with opts should be
+// treated as private by read endpoints.
+func objectIsPrivate(opts uploadOptions) bool {
+	return !opts.Public || configuredAccessMode() == accessModeUniformSigned
+}
+
+// impersonateServiceAccount returns the service account email signed URLs
+// should be minted as, via the IAM Credentials API, instead of requiring a
+// downloaded private key file. Empty when unset (the common case when a key
+// file or GCE/Cloud Run default credentials can sign directly).
+func impersonateServiceAccount() string {
+	return os.Getenv("IMPERSONATE_SERVICE_ACCOUNT")
+}
+
+// canonicalObjectURL is the URL GCS serves an object at once the bucket (or
+// the object itself) grants public read access.
+func canonicalObjectURL(name string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketName, name)
+}
+
+// signedObjectURL mints a V4 signed GET URL for name valid for ttl. When
+// IMPERSONATE_SERVICE_ACCOUNT is set, signing is delegated to the IAM
+// Credentials API via impersonated credentials so Cloud Run (which has no
+// downloaded service account key to sign with locally) can still produce
+// signed URLs.
+func signedObjectURL(ctx context.Context, bucket *storage.BucketHandle, name string, ttl time.Duration) (string, error) {
+	signOpts := &storage.SignedURLOptions{
+		Method:  http.MethodGet,
+		Scheme:  storage.SigningSchemeV4,
+		Expires: time.Now().Add(ttl),
+	}
+
+	sa := impersonateServiceAccount()
+	if sa == "" {
+		return bucket.SignedURL(name, signOpts)
+	}
+
+	tokenSource, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: sa,
+		Scopes:          []string{"https://www.googleapis.com/auth/cloud-platform"},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create impersonated credentials for %q: %w", sa, err)
+	}
+
+	iamClient, err := credentials.NewIamCredentialsClient(ctx, option.WithTokenSource(tokenSource))
+	if err != nil {
+		return "", fmt.Errorf("failed to create IAM credentials client: %w", err)
+	}
+	defer iamClient.Close()
+
+	signOpts.GoogleAccessID = sa
+	signOpts.SignBytes = func(b []byte) ([]byte, error) {
+		resp, err := iamClient.SignBlob(ctx, &credentialspb.SignBlobRequest{
+			Name:    fmt.Sprintf("projects/-/serviceAccounts/%s", sa),
+			Payload: b,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.SignedBlob, nil
+	}
+
+	return bucket.SignedURL(name, signOpts)
+}
+
+// finalizeObjectAccess makes an uploaded object reachable and returns the URL
+// callers should use to fetch it. A per-request ?public=0 always wins and
+// forces a signed URL regardless of the configured access mode; otherwise
+// the configured accessMode decides between legacy public ACLs, signed URLs,
+// and IAM-granted public access.
+func finalizeObjectAccess(ctx context.Context, bucket *storage.BucketHandle, obj *storage.ObjectHandle, name string, opts uploadOptions) (string, error) {
+	if !opts.Public {
+		url, err := signedObjectURL(ctx, bucket, name, opts.SignedURLTTL)
+		if err != nil {
+			return "", fmt.Errorf("upload succeeded but failed to sign URL for %q: %w", name, err)
+		}
+		return url, nil
+	}
+
+	switch configuredAccessMode() {
+	case accessModeUniformSigned:
+		return signedObjectURL(ctx, bucket, name, opts.SignedURLTTL)
+	case accessModeUniformIAM:
+		return canonicalObjectURL(name), nil
+	default:
+		// The object is already durably written at this point (wc.Close()
+		// already succeeded), and ACL mutation is expected to fail on
+		// Uniform Bucket-Level Access buckets — that's the whole reason
+		// uniform-signed/uniform-iam exist. Degrade gracefully rather than
+		// failing the upload over a non-public object that otherwise
+		// uploaded fine, matching the original server's behavior.
+		if err := obj.ACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
+			log.Printf("Warning: failed to set public access for %q (consider ACCESS_MODE=uniform-signed or uniform-iam for Uniform Bucket-Level Access buckets): %v", name, err)
+		}
+		return canonicalObjectURL(name), nil
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestConfiguredAccessMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		envVal string
+		want   accessMode
+	}{
+		{name: "unset defaults to public-acl", envVal: "", want: accessModePublicACL},
+		{name: "unrecognized value defaults to public-acl", envVal: "bogus", want: accessModePublicACL},
+		{name: "uniform-signed", envVal: "uniform-signed", want: accessModeUniformSigned},
+		{name: "uniform-iam", envVal: "uniform-iam", want: accessModeUniformIAM},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ACCESS_MODE", tt.envVal)
+			if got := configuredAccessMode(); got != tt.want {
+				t.Errorf("configuredAccessMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObjectIsPrivate(t *testing.T) {
+	tests := []struct {
+		name       string
+		accessMode string
+		opts       uploadOptions
+		want       bool
+	}{
+		{
+			name:       "public request, default access mode",
+			accessMode: "",
+			opts:       uploadOptions{Public: true},
+			want:       false,
+		},
+		{
+			name:       "request explicitly opts out of public access",
+			accessMode: "",
+			opts:       uploadOptions{Public: false},
+			want:       true,
+		},
+		{
+			name:       "public request under uniform-signed mode is still private",
+			accessMode: "uniform-signed",
+			opts:       uploadOptions{Public: true},
+			want:       true,
+		},
+		{
+			name:       "public request under uniform-iam mode stays public",
+			accessMode: "uniform-iam",
+			opts:       uploadOptions{Public: true},
+			want:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ACCESS_MODE", tt.accessMode)
+			if got := objectIsPrivate(tt.opts); got != tt.want {
+				t.Errorf("objectIsPrivate(%+v) = %v, want %v", tt.opts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalObjectURL(t *testing.T) {
+	prevBucket := bucketName
+	bucketName = "test-bucket"
+	defer func() { bucketName = prevBucket }()
+
+	want := "https://storage.googleapis.com/test-bucket/foo/bar.jpg"
+	if got := canonicalObjectURL("foo/bar.jpg"); got != want {
+		t.Errorf("canonicalObjectURL() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// objectSummary is the JSON shape returned by listHandler for each object.
+// PublicURL is left empty for objects uploaded as private (see
+// privateObjectMetadataKey) since handing out a bare storage.googleapis.com
+// URL for those would defeat the point of keeping them private; fetch those
+// via imageHandler instead, which mints a signed URL on demand.
+type objectSummary struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType"`
+	Created     string `json:"created"`
+	PublicURL   string `json:"publicUrl,omitempty"`
+	Private     bool   `json:"private,omitempty"`
+}
+
+// listResponse wraps a page of objects plus the token to fetch the next page.
+type listResponse struct {
+	Objects       []objectSummary `json:"objects"`
+	NextPageToken string          `json:"nextPageToken,omitempty"`
+}
+
+func listHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	pageToken := query.Get("pageToken")
+
+	maxResults := 100
+	if maxParam := query.Get("max"); maxParam != "" {
+		parsed, err := strconv.Atoi(maxParam)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "max must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		maxResults = parsed
+	}
+
+	ctx := r.Context()
+	bucket := storageClient.Bucket(bucketName)
+	it := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+
+	pager := iterator.NewPager(it, maxResults, pageToken)
+	var attrsPage []*storage.ObjectAttrs
+	nextToken, err := pager.NextPage(&attrsPage)
+	if err != nil {
+		log.Printf("Failed to list objects with prefix %q: %v", prefix, err)
+		http.Error(w, "Failed to list objects", http.StatusInternalServerError)
+		return
+	}
+
+	objects := make([]objectSummary, 0, len(attrsPage))
+	for _, attrs := range attrsPage {
+		summary := objectSummary{
+			Name:        attrs.Name,
+			Size:        attrs.Size,
+			ContentType: attrs.ContentType,
+			Created:     attrs.Created.Format("2006-01-02T15:04:05Z07:00"),
+		}
+		if attrs.Metadata[privateObjectMetadataKey] == privateObjectMetadataValue {
+			summary.Private = true
+		} else {
+			summary.PublicURL = canonicalObjectURL(attrs.Name)
+		}
+		objects = append(objects, summary)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(listResponse{Objects: objects, NextPageToken: nextToken}); err != nil {
+		log.Printf("Failed to encode list response: %v", err)
+	}
+}
+
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	bucket := storageClient.Bucket(bucketName)
+	obj := bucket.Object(path)
+
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		log.Printf("Failed to stat object %q: %v", path, err)
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	// Private objects were never meant to be proxied straight off the
+	// bucket by path — that would make the "keep it private" promise behind
+	// ?public=0 / uniform-signed mode cosmetic. Hand back a short-lived
+	// signed URL instead.
+	if attrs.Metadata[privateObjectMetadataKey] == privateObjectMetadataValue {
+		url, err := signedObjectURL(ctx, bucket, path, defaultSignedURLTTL)
+		if err != nil {
+			log.Printf("Failed to sign URL for private object %q: %v", path, err)
+			http.Error(w, "Failed to read image", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	offset, length, partial, err := parseRange(r.Header.Get("Range"), attrs.Size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	var reader *storage.Reader
+	if partial {
+		reader, err = obj.NewRangeReader(ctx, offset, length)
+	} else {
+		reader, err = obj.NewReader(ctx)
+	}
+	if err != nil {
+		log.Printf("Failed to open reader for %q: %v", path, err)
+		http.Error(w, "Failed to read image", http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", attrs.ContentType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, attrs.Size))
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(attrs.Size, 10))
+	}
+
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("Failed to stream object %q: %v", path, err)
+	}
+}
+
+// parseRange parses a single-range "bytes=start-end" Range header. It returns
+// partial=false when no Range header was supplied, in which case offset and
+// length are meaningless.
+func parseRange(header string, size int64) (offset, length int64, partial bool, err error) {
+	if header == "" {
+		return 0, 0, false, nil
+	}
+
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header {
+		return 0, 0, false, fmt.Errorf("unsupported Range unit")
+	}
+
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false, fmt.Errorf("malformed Range header")
+	}
+
+	var start, end int64
+	if parts[0] == "" {
+		// Suffix range: "bytes=-N" means the last N bytes.
+		suffixLen, convErr := strconv.ParseInt(parts[1], 10, 64)
+		if convErr != nil || suffixLen <= 0 {
+			return 0, 0, false, fmt.Errorf("malformed Range header")
+		}
+		if suffixLen > size {
+			suffixLen = size
+		}
+		return size - suffixLen, suffixLen, true, nil
+	}
+
+	start, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, fmt.Errorf("malformed Range header")
+	}
+
+	if parts[1] == "" {
+		return start, size - start, true, nil
+	}
+
+	end, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false, fmt.Errorf("malformed Range header")
+	}
+	if end >= size {
+		end = size - 1
+	}
+
+	return start, end - start + 1, true, nil
+}
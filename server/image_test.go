@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	const size = int64(1000)
+
+	tests := []struct {
+		name        string
+		header      string
+		wantOffset  int64
+		wantLength  int64
+		wantPartial bool
+		wantErr     bool
+	}{
+		{name: "no header", header: "", wantPartial: false},
+		{name: "bounded range", header: "bytes=0-99", wantOffset: 0, wantLength: 100, wantPartial: true},
+		{name: "open-ended range", header: "bytes=900-", wantOffset: 900, wantLength: 100, wantPartial: true},
+		{name: "suffix range", header: "bytes=-500", wantOffset: 500, wantLength: 500, wantPartial: true},
+		{name: "suffix range larger than size is clamped", header: "bytes=-5000", wantOffset: 0, wantLength: size, wantPartial: true},
+		{name: "end past size is clamped", header: "bytes=900-2000", wantOffset: 900, wantLength: 100, wantPartial: true},
+		{name: "unsupported unit", header: "items=0-100", wantErr: true},
+		{name: "malformed spec", header: "bytes=abc-200", wantErr: true},
+		{name: "start at or past size", header: "bytes=1000-1100", wantErr: true},
+		{name: "end before start", header: "bytes=200-100", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset, length, partial, err := parseRange(tt.header, size)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseRange(%q) = nil error, want error", tt.header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRange(%q) unexpected error: %v", tt.header, err)
+			}
+			if partial != tt.wantPartial {
+				t.Errorf("parseRange(%q) partial = %v, want %v", tt.header, partial, tt.wantPartial)
+			}
+			if !partial {
+				return
+			}
+			if offset != tt.wantOffset {
+				t.Errorf("parseRange(%q) offset = %d, want %d", tt.header, offset, tt.wantOffset)
+			}
+			if length != tt.wantLength {
+				t.Errorf("parseRange(%q) length = %d, want %d", tt.header, length, tt.wantLength)
+			}
+		})
+	}
+}
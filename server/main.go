@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -53,11 +54,15 @@ func main() {
 
 	// Set up routes
 	http.HandleFunc("/upload", uploadHandler)
+	http.HandleFunc("/image", imageHandler)
+	http.HandleFunc("/list", listHandler)
 	http.HandleFunc("/", homeHandler)
 
 	// Start server
 	fmt.Printf("Server starting on port %s\n", port)
 	fmt.Printf("Upload endpoint available at: /upload\n")
+	fmt.Printf("Image endpoint available at: /image?path=...\n")
+	fmt.Printf("List endpoint available at: /list?prefix=...\n")
 	fmt.Printf("Using storage bucket: %s\n", bucketName)
 
 	if err := http.ListenAndServe(port, nil); err != nil {
@@ -83,21 +88,46 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
     <p>Upload endpoint: <code>/upload</code></p>
     <p>Method: POST</p>
     <p>Content-Type: multipart/form-data</p>
+    <p>Image endpoint: <code>/image?path=...</code> (GET, supports Range)</p>
+    <p>List endpoint: <code>/list?prefix=...&amp;max=...&amp;pageToken=...</code> (GET)</p>
+    <p>Upload options: <code>?convert=webp</code>, <code>?thumbnail=1</code>, <code>?gzip=1</code>, <code>?cacheable=0</code>, <code>?public=0</code></p>
 </body>
 </html>
 `)
 }
 
+// contentTypeForExt maps a file extension to the content type we trust for
+// GCS objects (we don't trust the multipart Content-Type header).
+func contentTypeForExt(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".bmp":
+		return "image/bmp"
+	case ".webp":
+		return "image/webp"
+	default:
+		return "application/octet-stream"
+	}
+}
+
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	// Reject oversized bodies before we spend any work parsing them.
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes())
+
 	// Parse the multipart form with a max memory of 32MB
 	err := r.ParseMultipartForm(32 << 20) // 32MB
 	if err != nil {
-		http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
+		http.Error(w, "Failed to parse multipart form (or file exceeds MAX_UPLOAD_BYTES)", http.StatusBadRequest)
 		return
 	}
 
@@ -128,112 +158,103 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	isJPEG := strings.ToLower(ext) == ".jpg" || strings.ToLower(ext) == ".jpeg"
+	if isJPEG && handler.Size < 4 {
+		log.Printf("File too small to be valid JPEG: %d bytes", handler.Size)
+		http.Error(w, "File too small to be valid JPEG", http.StatusBadRequest)
+		return
+	}
+
 	// Generate unique filename with timestamp
 	timestamp := time.Now().Format("20060102_150405")
 	// Remove extension from original filename and add it back properly
 	baseName := strings.TrimSuffix(handler.Filename, ext)
 	filename := fmt.Sprintf("%s_%s%s", timestamp, baseName, ext)
 
-	// Read file data to validate JPEG and get proper content type
-	fileData, err := io.ReadAll(file)
-	if err != nil {
-		log.Printf("Failed to read file data: %v", err)
-		http.Error(w, "Failed to read file data", http.StatusInternalServerError)
-		return
+	// Derive from the request context (not context.Background()) so the
+	// upload aborts if the client disconnects, and so isTransientUploadError's
+	// context.DeadlineExceeded branch can actually fire.
+	ctx, cancel := context.WithTimeout(r.Context(), uploadContextTimeout)
+	defer cancel()
+	bucket := storageClient.Bucket(bucketName)
+
+	// Determine whether the caller wants a WebP transcode instead of the
+	// original encoding.
+	convertTo := r.URL.Query().Get("convert")
+	if headerConvert := r.Header.Get("X-Convert-To"); headerConvert != "" {
+		convertTo = headerConvert
 	}
 
-	log.Printf("Read %d bytes from file", len(fileData))
+	opts := parseUploadOptions(r)
 
-	// Validate JPEG data if it's supposed to be a JPEG
-	if strings.ToLower(ext) == ".jpg" || strings.ToLower(ext) == ".jpeg" {
-		if len(fileData) < 4 {
-			log.Printf("File too small to be valid JPEG: %d bytes", len(fileData))
-			http.Error(w, "File too small to be valid JPEG", http.StatusBadRequest)
+	var thumbName, url, thumbURL string
+	if strings.ToLower(convertTo) == "webp" {
+		// Transcoding needs the whole image decoded in memory, so this path
+		// still buffers; everything else streams straight through to GCS.
+		fileData, err := io.ReadAll(file)
+		if err != nil {
+			log.Printf("Failed to read file data: %v", err)
+			http.Error(w, "Failed to read file data", http.StatusInternalServerError)
 			return
 		}
 
-		// Check JPEG magic bytes
-		if fileData[0] != 0xFF || fileData[1] != 0xD8 || fileData[2] != 0xFF {
+		if isJPEG && (fileData[0] != 0xFF || fileData[1] != 0xD8 || fileData[2] != 0xFF) {
 			log.Printf("Invalid JPEG header. Expected FF D8 FF, got %02X %02X %02X",
 				fileData[0], fileData[1], fileData[2])
 			http.Error(w, "Invalid JPEG file format", http.StatusBadRequest)
 			return
 		}
 
-		// Check for JPEG end marker
-		if len(fileData) >= 2 {
-			if fileData[len(fileData)-2] != 0xFF || fileData[len(fileData)-1] != 0xD9 {
-				log.Printf("WARNING: Missing JPEG end marker. Expected FF D9, got %02X %02X",
-					fileData[len(fileData)-2], fileData[len(fileData)-1])
-			}
+		result, err := convertAndUpload(ctx, bucket, fileData, timestamp, baseName, r, opts)
+		if err != nil {
+			log.Printf("Failed to convert and upload %s: %v", handler.Filename, err)
+			http.Error(w, "Failed to convert image to WebP", http.StatusInternalServerError)
+			return
+		}
+		filename, url, thumbName, thumbURL = result.Filename, result.URL, result.ThumbName, result.ThumbURL
+	} else {
+		contentType := contentTypeForExt(ext)
+		log.Printf("Setting GCS Content-Type: %s", contentType)
+
+		var source io.ReadSeeker = file
+		var jpegReader *jpegValidatingReader
+		if isJPEG {
+			jpegReader = newJPEGValidatingReader(file)
+			source = jpegReader
 		}
 
-		log.Printf("✓ JPEG validation passed - SOI: %02X %02X %02X, EOI: %02X %02X",
-			fileData[0], fileData[1], fileData[2],
-			fileData[len(fileData)-2], fileData[len(fileData)-1])
-	}
-
-	// Upload to Google Cloud Storage
-	ctx := context.Background()
-	bucket := storageClient.Bucket(bucketName)
-	obj := bucket.Object(filename)
-
-	// Create a writer to the GCS object
-	wc := obj.NewWriter(ctx)
-
-	// Set content type based on file extension (don't trust multipart headers)
-	switch strings.ToLower(ext) {
-	case ".jpg", ".jpeg":
-		wc.ContentType = "image/jpeg"
-	case ".png":
-		wc.ContentType = "image/png"
-	case ".gif":
-		wc.ContentType = "image/gif"
-	case ".bmp":
-		wc.ContentType = "image/bmp"
-	case ".webp":
-		wc.ContentType = "image/webp"
-	default:
-		wc.ContentType = "application/octet-stream"
-	}
-
-	log.Printf("Setting GCS Content-Type: %s", wc.ContentType)
-
-	// Write the file data to GCS
-	bytesWritten, err := wc.Write(fileData)
-	if err != nil {
-		wc.Close()
-		log.Printf("Failed to write to GCS: %v", err)
-		http.Error(w, "Failed to upload file to storage", http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("Wrote %d bytes to GCS", bytesWritten)
+		uploadedURL, err := uploadStreamWithRetry(ctx, bucket, filename, source, contentType, opts)
+		if err != nil {
+			if errors.Is(err, errInvalidJPEGHeader) {
+				log.Printf("Invalid JPEG file: %v", err)
+				http.Error(w, "Invalid JPEG file format", http.StatusBadRequest)
+				return
+			}
+			log.Printf("Failed to upload to GCS: %v", err)
+			http.Error(w, "Failed to upload file to storage", http.StatusInternalServerError)
+			return
+		}
+		url = uploadedURL
 
-	// Close the writer to finalize the upload
-	if err := wc.Close(); err != nil {
-		log.Printf("Failed to close GCS writer: %v", err)
-		http.Error(w, "Failed to finalize upload", http.StatusInternalServerError)
-		return
+		if jpegReader != nil && !jpegReader.hasValidEOI() {
+			log.Printf("WARNING: Missing JPEG end marker (FF D9) for %s", filename)
+		}
 	}
 
 	log.Printf("✓ Successfully uploaded %s to GCS bucket %s", filename, bucketName)
 
-	// Make the object publicly readable
-	acl := obj.ACL()
-	if err := acl.Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
-		log.Printf("Warning: Failed to set public access for %s: %v", filename, err)
-	}
-
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, `{
 		"success": true,
 		"message": "File uploaded successfully to cloud storage",
 		"filename": "%s",
+		"url": "%s",
+		"thumbnail": "%s",
+		"thumbnailUrl": "%s",
 		"bucket": "%s",
 		"size": %d
-	}`, filename, bucketName, handler.Size)
+	}`, filename, url, thumbName, thumbURL, bucketName, handler.Size)
 
 	log.Printf("File uploaded successfully to GCS: %s/%s (size: %d bytes)", bucketName, filename, handler.Size)
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/cenkalti/backoff/v4"
+	"google.golang.org/api/googleapi"
+)
+
+// defaultMaxUploadBytes matches the multipart form's historical in-memory
+// threshold; override with MAX_UPLOAD_BYTES for larger or smaller deployments.
+const defaultMaxUploadBytes = 32 << 20
+
+// uploadContextTimeout bounds the request's upload context. It's kept a
+// little above the retry policy's MaxElapsedTime below so the final retry
+// attempt isn't cut off by the deadline before backoff gives up on its own.
+const uploadContextTimeout = 6 * time.Minute
+
+// maxUploadBytes returns the configured request body size cap.
+func maxUploadBytes() int64 {
+	if raw := os.Getenv("MAX_UPLOAD_BYTES"); raw != "" {
+		if parsed, err := strconv.ParseInt(raw, 10, 64); err == nil && parsed > 0 {
+			return parsed
+		}
+	}
+	return defaultMaxUploadBytes
+}
+
+// jpegValidatingReader wraps a JPEG source and checks its magic bytes as they
+// flow through, so callers can stream straight to the upload destination
+// instead of buffering the whole file just to validate it.
+type jpegValidatingReader struct {
+	r               io.ReadSeeker
+	seen            int64
+	header          [3]byte
+	headerValidated bool
+	tail            [2]byte
+}
+
+// errInvalidJPEGHeader is returned (wrapped) the moment a bad SOI marker is
+// observed, so the caller can abort the upload early.
+var errInvalidJPEGHeader = errors.New("invalid JPEG header")
+
+func newJPEGValidatingReader(r io.ReadSeeker) *jpegValidatingReader {
+	return &jpegValidatingReader{r: r}
+}
+
+// Seek delegates to the underlying seeker so a jpegValidatingReader can be
+// used directly as the retryable source in uploadStreamWithRetry. Seeking
+// back to the start also resets the validator state for the retry attempt.
+func (jr *jpegValidatingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := jr.r.Seek(offset, whence)
+	if err == nil && offset == 0 && whence == io.SeekStart {
+		jr.seen = 0
+		jr.headerValidated = false
+		jr.header = [3]byte{}
+		jr.tail = [2]byte{}
+	}
+	return pos, err
+}
+
+func (jr *jpegValidatingReader) Read(p []byte) (int, error) {
+	n, err := jr.r.Read(p)
+	for _, b := range p[:n] {
+		if jr.seen < int64(len(jr.header)) {
+			jr.header[jr.seen] = b
+		}
+		jr.tail[0], jr.tail[1] = jr.tail[1], b
+		jr.seen++
+	}
+
+	if !jr.headerValidated && jr.seen >= int64(len(jr.header)) {
+		jr.headerValidated = true
+		if jr.header[0] != 0xFF || jr.header[1] != 0xD8 || jr.header[2] != 0xFF {
+			return n, fmt.Errorf("%w: expected FF D8 FF, got %02X %02X %02X",
+				errInvalidJPEGHeader, jr.header[0], jr.header[1], jr.header[2])
+		}
+	}
+
+	return n, err
+}
+
+// hasValidEOI reports whether the last two bytes seen were the JPEG EOI
+// marker (FF D9). Missing it is only logged as a warning, matching the
+// original buffered validation's behavior.
+func (jr *jpegValidatingReader) hasValidEOI() bool {
+	return jr.seen >= 2 && jr.tail[0] == 0xFF && jr.tail[1] == 0xD9
+}
+
+// uploadStreamWithRetry streams source into the named object, computing an
+// MD5 as it goes so GCS can reject a corrupted transfer server-side, and
+// retries the whole attempt with exponential backoff on transient errors.
+// source must be seekable so a retry can restart the upload from byte zero.
+// It returns the URL callers should use to fetch the object afterwards.
+func uploadStreamWithRetry(ctx context.Context, bucket *storage.BucketHandle, name string, source io.ReadSeeker, contentType string, opts uploadOptions) (string, error) {
+	policy := backoff.NewExponentialBackOff()
+	policy.InitialInterval = 500 * time.Millisecond
+	policy.MaxInterval = 30 * time.Second
+	policy.MaxElapsedTime = 5 * time.Minute
+
+	var accessURL string
+	operation := func() error {
+		if _, err := source.Seek(0, io.SeekStart); err != nil {
+			return backoff.Permanent(err)
+		}
+
+		// wc.Close() always finalizes whatever has been written as a real
+		// object; there is no way to abort an in-flight write other than
+		// canceling the context NewWriter was given. So on a permanent
+		// failure (e.g. a bad JPEG header caught mid-copy) we cancel instead
+		// of closing, to avoid committing invalid bytes to the bucket.
+		attemptCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		obj := bucket.Object(name)
+		wc := obj.NewWriter(attemptCtx)
+		wc.ContentType = contentType
+		wc.CacheControl = cacheControlFor(opts)
+		if opts.Gzip {
+			wc.ContentEncoding = "gzip"
+		}
+		if objectIsPrivate(opts) {
+			wc.Metadata = map[string]string{privateObjectMetadataKey: privateObjectMetadataValue}
+		}
+
+		hasher := md5.New()
+		dest := io.Writer(io.MultiWriter(wc, hasher))
+		var gw *gzip.Writer
+		if opts.Gzip {
+			gw = gzip.NewWriter(dest)
+			dest = gw
+		}
+
+		if _, err := io.Copy(dest, source); err != nil {
+			if errors.Is(err, errInvalidJPEGHeader) || !isTransientUploadError(err) {
+				cancel()
+				return backoff.Permanent(err)
+			}
+			wc.Close()
+			return err
+		}
+
+		if gw != nil {
+			if err := gw.Close(); err != nil {
+				cancel()
+				return backoff.Permanent(fmt.Errorf("failed to finalize gzip stream: %w", err))
+			}
+		}
+
+		wc.MD5 = hasher.Sum(nil)
+		if err := wc.Close(); err != nil {
+			if isTransientUploadError(err) {
+				return err
+			}
+			return backoff.Permanent(err)
+		}
+
+		url, err := finalizeObjectAccess(ctx, bucket, obj, name, opts)
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		accessURL = url
+
+		return nil
+	}
+
+	if err := backoff.Retry(operation, backoff.WithContext(policy, ctx)); err != nil {
+		return "", err
+	}
+	return accessURL, nil
+}
+
+// isTransientUploadError reports whether err is worth retrying: GCS errors
+// with 429/500/502/503/504 status codes, or a context deadline that fired
+// mid-upload.
+func isTransientUploadError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case 429, 500, 502, 503, 504:
+			return true
+		}
+	}
+
+	return false
+}
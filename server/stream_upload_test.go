@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsTransientUploadError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "context deadline exceeded", err: context.DeadlineExceeded, want: true},
+		{name: "googleapi 429", err: &googleapi.Error{Code: 429}, want: true},
+		{name: "googleapi 500", err: &googleapi.Error{Code: 500}, want: true},
+		{name: "googleapi 502", err: &googleapi.Error{Code: 502}, want: true},
+		{name: "googleapi 503", err: &googleapi.Error{Code: 503}, want: true},
+		{name: "googleapi 504", err: &googleapi.Error{Code: 504}, want: true},
+		{name: "googleapi 400 is permanent", err: &googleapi.Error{Code: 400}, want: false},
+		{name: "googleapi 404 is permanent", err: &googleapi.Error{Code: 404}, want: false},
+		{name: "plain error is permanent", err: errors.New("boom"), want: false},
+		{name: "invalid jpeg header is permanent", err: errInvalidJPEGHeader, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientUploadError(tt.err); got != tt.want {
+				t.Errorf("isTransientUploadError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// validJPEG is just enough bytes to exercise the SOI/EOI tracking: a valid
+// header, some payload, and a valid end marker.
+var validJPEG = []byte{0xFF, 0xD8, 0xFF, 0x01, 0x02, 0x03, 0xFF, 0xD9}
+
+func TestJPEGValidatingReader_ValidData(t *testing.T) {
+	jr := newJPEGValidatingReader(bytes.NewReader(validJPEG))
+
+	data, err := io.ReadAll(jr)
+	if err != nil {
+		t.Fatalf("unexpected error reading valid JPEG: %v", err)
+	}
+	if !bytes.Equal(data, validJPEG) {
+		t.Errorf("read data = %v, want %v", data, validJPEG)
+	}
+	if !jr.hasValidEOI() {
+		t.Error("hasValidEOI() = false, want true for data ending in FF D9")
+	}
+}
+
+func TestJPEGValidatingReader_InvalidHeader(t *testing.T) {
+	bad := append([]byte{0x00, 0x00, 0x00}, validJPEG[3:]...)
+	jr := newJPEGValidatingReader(bytes.NewReader(bad))
+
+	_, err := io.ReadAll(jr)
+	if !errors.Is(err, errInvalidJPEGHeader) {
+		t.Fatalf("ReadAll error = %v, want errInvalidJPEGHeader", err)
+	}
+}
+
+func TestJPEGValidatingReader_MissingEOI(t *testing.T) {
+	noEOI := append([]byte{}, validJPEG[:len(validJPEG)-2]...)
+	noEOI = append(noEOI, 0x00, 0x00)
+	jr := newJPEGValidatingReader(bytes.NewReader(noEOI))
+
+	if _, err := io.ReadAll(jr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if jr.hasValidEOI() {
+		t.Error("hasValidEOI() = true, want false when last two bytes aren't FF D9")
+	}
+}
+
+func TestJPEGValidatingReader_SeekResetsValidatorState(t *testing.T) {
+	jr := newJPEGValidatingReader(bytes.NewReader(validJPEG))
+
+	// Read partway through, then seek back to the start as a retry would.
+	buf := make([]byte, 4)
+	if _, err := jr.Read(buf); err != nil {
+		t.Fatalf("unexpected error on first read: %v", err)
+	}
+
+	if _, err := jr.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("unexpected error seeking: %v", err)
+	}
+
+	data, err := io.ReadAll(jr)
+	if err != nil {
+		t.Fatalf("unexpected error after seek: %v", err)
+	}
+	if !bytes.Equal(data, validJPEG) {
+		t.Errorf("read data after seek = %v, want %v", data, validJPEG)
+	}
+	if !jr.hasValidEOI() {
+		t.Error("hasValidEOI() = false after seek+reread, want true")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"github.com/chai2010/webp"
+	"golang.org/x/image/draw"
+	"golang.org/x/sync/errgroup"
+)
+
+// webpQuality is the lossy encode quality used by the native encoder.
+const webpQuality = 80.0
+
+// defaultThumbMaxDim is used when a request asks for a thumbnail without
+// specifying ?thumbMax=.
+const defaultThumbMaxDim = 320
+
+// webpEncoder selects how JPEG/PNG bytes are turned into WebP. It defaults to
+// the CGO-backed native encoder but can be switched to shelling out to cwebp
+// via the WEBP_ENCODER env var. The choice is made at runtime: the native
+// bindings are linked in either way, so the build still requires CGO.
+type webpEncoder string
+
+const (
+	webpEncoderNative webpEncoder = "native"
+	webpEncoderCwebp  webpEncoder = "cwebp"
+)
+
+func configuredWebPEncoder() webpEncoder {
+	switch webpEncoder(os.Getenv("WEBP_ENCODER")) {
+	case webpEncoderCwebp:
+		return webpEncoderCwebp
+	default:
+		return webpEncoderNative
+	}
+}
+
+// decodeImage sniffs and decodes JPEG or PNG source bytes.
+func decodeImage(data []byte) (image.Image, error) {
+	if img, err := jpeg.Decode(bytes.NewReader(data)); err == nil {
+		return img, nil
+	}
+	if img, err := png.Decode(bytes.NewReader(data)); err == nil {
+		return img, nil
+	}
+	return nil, fmt.Errorf("unsupported image format for conversion (expected JPEG or PNG)")
+}
+
+// encodeWebP converts img to WebP bytes using the configured encoder.
+func encodeWebP(img image.Image) ([]byte, error) {
+	switch configuredWebPEncoder() {
+	case webpEncoderCwebp:
+		return encodeWebPViaCwebp(img)
+	default:
+		return encodeWebPNative(img)
+	}
+}
+
+// encodeWebPNative uses the CGO-backed libwebp bindings directly.
+func encodeWebPNative(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, &webp.Options{Lossless: false, Quality: webpQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode WebP: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeWebPViaCwebp shells out to the cwebp binary instead of calling the
+// linked libwebp bindings. It round-trips through temp files since cwebp
+// operates on the filesystem.
+func encodeWebPViaCwebp(img image.Image) ([]byte, error) {
+	inFile, err := os.CreateTemp("", "upload-*.png")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp input file: %w", err)
+	}
+	defer os.Remove(inFile.Name())
+	defer inFile.Close()
+
+	if err := png.Encode(inFile, img); err != nil {
+		return nil, fmt.Errorf("failed to encode temp PNG for cwebp: %w", err)
+	}
+	if err := inFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close temp input file: %w", err)
+	}
+
+	outFile, err := os.CreateTemp("", "upload-*.webp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp output file: %w", err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	cmd := exec.Command("cwebp", "-quiet", inFile.Name(), "-o", outFile.Name())
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("cwebp failed: %w", err)
+	}
+
+	return os.ReadFile(outFile.Name())
+}
+
+// resizeToThumbnail scales img down so its longest side is at most maxDim,
+// preserving aspect ratio. Images already within bounds are returned as-is.
+func resizeToThumbnail(img image.Image, maxDim int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxDim && height <= maxDim {
+		return img
+	}
+
+	scale := float64(maxDim) / float64(width)
+	if height > width {
+		scale = float64(maxDim) / float64(height)
+	}
+
+	dstWidth := int(float64(width) * scale)
+	dstHeight := int(float64(height) * scale)
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// conversionResult reports the object names and access URLs produced by
+// convertAndUpload.
+type conversionResult struct {
+	Filename  string
+	URL       string
+	ThumbName string
+	ThumbURL  string
+}
+
+// convertAndUpload decodes fileData, re-encodes it as WebP, and uploads the
+// full-size result under "<timestamp>_<baseName>.webp". If the request asks
+// for a thumbnail (?thumbnail=1, optionally sized via ?thumbMax=), it is
+// generated and uploaded under the "thumbs/" prefix concurrently with the
+// full-size upload.
+func convertAndUpload(ctx context.Context, bucket *storage.BucketHandle, fileData []byte, timestamp, baseName string, r *http.Request, opts uploadOptions) (conversionResult, error) {
+	img, err := decodeImage(fileData)
+	if err != nil {
+		return conversionResult{}, err
+	}
+
+	full, err := encodeWebP(img)
+	if err != nil {
+		return conversionResult{}, err
+	}
+
+	result := conversionResult{Filename: fmt.Sprintf("%s_%s.webp", timestamp, baseName)}
+
+	wantThumb := r.URL.Query().Get("thumbnail") == "1"
+	thumbMax := defaultThumbMaxDim
+	if v := r.URL.Query().Get("thumbMax"); v != "" {
+		if parsed, convErr := strconv.Atoi(v); convErr == nil && parsed > 0 {
+			thumbMax = parsed
+		}
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+
+	group.Go(func() error {
+		url, err := uploadObjectBytes(groupCtx, bucket, result.Filename, full, "image/webp", opts)
+		if err != nil {
+			return err
+		}
+		result.URL = url
+		return nil
+	})
+
+	if wantThumb {
+		result.ThumbName = fmt.Sprintf("thumbs/%s_%s.webp", timestamp, baseName)
+		group.Go(func() error {
+			thumbImg := resizeToThumbnail(img, thumbMax)
+			thumbBytes, err := encodeWebP(thumbImg)
+			if err != nil {
+				return err
+			}
+			url, err := uploadObjectBytes(groupCtx, bucket, result.ThumbName, thumbBytes, "image/webp", opts)
+			if err != nil {
+				return err
+			}
+			result.ThumbURL = url
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return conversionResult{}, err
+	}
+
+	return result, nil
+}
+
+// uploadObjectBytes writes data to the named object, going through the same
+// retry-with-MD5-checksum machinery as the streaming upload path (data is
+// already fully in memory here, so bytes.NewReader gives it the io.ReadSeeker
+// a retry needs to restart from byte zero).
+func uploadObjectBytes(ctx context.Context, bucket *storage.BucketHandle, name string, data []byte, contentType string, opts uploadOptions) (string, error) {
+	return uploadStreamWithRetry(ctx, bucket, name, bytes.NewReader(data), contentType, opts)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestResizeToThumbnail(t *testing.T) {
+	tests := []struct {
+		name       string
+		width      int
+		height     int
+		maxDim     int
+		wantWidth  int
+		wantHeight int
+		wantSame   bool
+	}{
+		{
+			name:     "already within bounds is returned unchanged",
+			width:    100,
+			height:   80,
+			maxDim:   320,
+			wantSame: true,
+		},
+		{
+			name:       "wide image scales down by width",
+			width:      1000,
+			height:     500,
+			maxDim:     100,
+			wantWidth:  100,
+			wantHeight: 50,
+		},
+		{
+			name:       "tall image scales down by height",
+			width:      500,
+			height:     1000,
+			maxDim:     100,
+			wantWidth:  50,
+			wantHeight: 100,
+		},
+		{
+			name:       "square image at the limit is returned unchanged",
+			width:      320,
+			height:     320,
+			maxDim:     320,
+			wantSame:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))
+			got := resizeToThumbnail(src, tt.maxDim)
+
+			if tt.wantSame {
+				if got != image.Image(src) {
+					t.Errorf("resizeToThumbnail() returned a new image for an already-in-bounds source")
+				}
+				return
+			}
+
+			bounds := got.Bounds()
+			if bounds.Dx() != tt.wantWidth || bounds.Dy() != tt.wantHeight {
+				t.Errorf("resizeToThumbnail() size = %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseUploadOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		wantGzip      bool
+		wantCacheable bool
+		wantPublic    bool
+		wantSignedTTL time.Duration
+	}{
+		{
+			name:          "defaults",
+			url:           "/upload",
+			wantCacheable: true,
+			wantPublic:    true,
+			wantSignedTTL: defaultSignedURLTTL,
+		},
+		{
+			name:          "gzip enabled",
+			url:           "/upload?gzip=1",
+			wantGzip:      true,
+			wantCacheable: true,
+			wantPublic:    true,
+			wantSignedTTL: defaultSignedURLTTL,
+		},
+		{
+			name:          "cacheable disabled",
+			url:           "/upload?cacheable=0",
+			wantCacheable: false,
+			wantPublic:    true,
+			wantSignedTTL: defaultSignedURLTTL,
+		},
+		{
+			name:          "public disabled",
+			url:           "/upload?public=0",
+			wantCacheable: true,
+			wantPublic:    false,
+			wantSignedTTL: defaultSignedURLTTL,
+		},
+		{
+			name:          "custom signed URL TTL",
+			url:           "/upload?public=0&signedUrlTTL=60",
+			wantCacheable: true,
+			wantPublic:    false,
+			wantSignedTTL: 60 * time.Second,
+		},
+		{
+			name:          "non-numeric signed URL TTL falls back to default",
+			url:           "/upload?signedUrlTTL=soon",
+			wantCacheable: true,
+			wantPublic:    true,
+			wantSignedTTL: defaultSignedURLTTL,
+		},
+		{
+			name:          "non-positive signed URL TTL falls back to default",
+			url:           "/upload?signedUrlTTL=-5",
+			wantCacheable: true,
+			wantPublic:    true,
+			wantSignedTTL: defaultSignedURLTTL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.url, nil)
+			opts := parseUploadOptions(r)
+
+			if opts.Gzip != tt.wantGzip {
+				t.Errorf("Gzip = %v, want %v", opts.Gzip, tt.wantGzip)
+			}
+			if opts.Cacheable != tt.wantCacheable {
+				t.Errorf("Cacheable = %v, want %v", opts.Cacheable, tt.wantCacheable)
+			}
+			if opts.Public != tt.wantPublic {
+				t.Errorf("Public = %v, want %v", opts.Public, tt.wantPublic)
+			}
+			if opts.SignedURLTTL != tt.wantSignedTTL {
+				t.Errorf("SignedURLTTL = %v, want %v", opts.SignedURLTTL, tt.wantSignedTTL)
+			}
+		})
+	}
+}
+
+func TestCacheControlFor(t *testing.T) {
+	if got := cacheControlFor(uploadOptions{Cacheable: true}); got != defaultCacheControl {
+		t.Errorf("cacheControlFor(Cacheable: true) = %q, want %q", got, defaultCacheControl)
+	}
+	if got := cacheControlFor(uploadOptions{Cacheable: false}); got != noCacheControl {
+		t.Errorf("cacheControlFor(Cacheable: false) = %q, want %q", got, noCacheControl)
+	}
+}